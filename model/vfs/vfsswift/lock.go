@@ -0,0 +1,211 @@
+package vfsswift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ncw/swift/v2"
+)
+
+// lockContainerSuffix is appended to a container name to get the name of the
+// sibling container in which its lock object is stored, e.g.
+// "cozy-io-cozy-xxx-locks" for the "cozy-io-cozy-xxx" container.
+const lockContainerSuffix = "-locks"
+
+// lockObjectName is the name of the object used as a lock inside a lock
+// container. Its body is a JSON-encoded lockPayload.
+const lockObjectName = ".lock"
+
+// lockStaleAfter is the age after which a lock is considered abandoned (its
+// holder presumably crashed before releasing it) and can be broken by
+// another node trying to acquire it.
+const lockStaleAfter = 2 * time.Minute
+
+// lockRenewInterval is the delay between two renewals of a held lock. It
+// must stay well under lockStaleAfter so that a live holder never sees its
+// lock broken from under it.
+const lockRenewInterval = 30 * time.Second
+
+// lockPollInterval is how long Lock waits between two attempts to acquire a
+// lock that is currently held by another, live node.
+const lockPollInterval = 100 * time.Millisecond
+
+// lockAcquireTimeout bounds how long Lock will wait for a lock held by
+// another, live node to be released, as a safety net against waiting
+// forever if that node never calls Unlock (short of crashing, in which case
+// breakStaleLock already takes over once lockStaleAfter has passed). It is
+// a var, not a const, so that tests can shrink it.
+var lockAcquireTimeout = 5 * time.Minute
+
+// ErrLocked is returned by Lock when the container is still locked by
+// another (live) node after lockAcquireTimeout has elapsed. Callers can
+// check for it with errors.Is to distinguish this from other failures.
+var ErrLocked = errors.New("vfsswift: container is locked by another node")
+
+// lockPayload is the JSON body stored in the lock object, so that a
+// conflicting acquirer (or an operator) can tell who holds the lock and
+// since when.
+type lockPayload struct {
+	NodeID     string    `json:"node_id"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+var nodeID = computeNodeID()
+
+func computeNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Lock acquires an exclusive lock on the given container, so that two
+// cozy-stack nodes cannot run mutating operations (DeleteContainer,
+// imports, migrations...) on it concurrently. It stores a lock object (whose
+// body records the holder and its acquisition time) in a sibling
+// "<container>-locks" container, created with Swift's
+// "If-None-Match: *" conditional PUT so that only one acquirer can ever
+// win the race. If the lock is already held, Lock blocks, polling every
+// lockPollInterval, until it is released, broken for being stale (older
+// than lockStaleAfter), ctx is done, or lockAcquireTimeout elapses (in
+// which case it returns ErrLocked). The lock is renewed in the background
+// until the returned Unlock function is called.
+func Lock(ctx context.Context, c *swift.Connection, container string) (func() error, error) {
+	lockContainer := container + lockContainerSuffix
+	if err := c.ContainerCreate(ctx, lockContainer, nil); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockAcquireTimeout)
+	for {
+		err := acquireLockObject(ctx, c, lockContainer)
+		if err == nil {
+			break
+		}
+		if !isPreconditionFailed(err) {
+			return nil, err
+		}
+
+		broke, err := breakStaleLock(ctx, c, lockContainer)
+		if err != nil {
+			return nil, err
+		}
+		if broke {
+			// The stale lock was removed: loop around and try to acquire
+			// again right away.
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrLocked
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go renewLock(ctx, c, lockContainer, stop, stopped)
+
+	unlock := func() error {
+		close(stop)
+		<-stopped
+		err := c.ObjectDelete(ctx, lockContainer, lockObjectName)
+		if err == swift.ObjectNotFound {
+			return nil
+		}
+		return err
+	}
+	return unlock, nil
+}
+
+func acquireLockObject(ctx context.Context, c *swift.Connection, lockContainer string) error {
+	body, err := json.Marshal(lockPayload{NodeID: nodeID, AcquiredAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	w, err := c.ObjectCreate(ctx, lockContainer, lockObjectName, false, "", "application/json",
+		swift.Headers{"If-None-Match": "*"})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// breakStaleLock checks the age of the current lock holder and, if it is
+// older than lockStaleAfter, deletes it so that a fresh acquisition can be
+// attempted. It returns true if the lock object is gone, either because a
+// stale lock was broken or because it was released concurrently.
+func breakStaleLock(ctx context.Context, c *swift.Connection, lockContainer string) (bool, error) {
+	r, _, err := c.ObjectOpen(ctx, lockContainer, lockObjectName, false, nil)
+	if err == swift.ObjectNotFound {
+		// The lock was released concurrently: nothing to break.
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	body, err := ioutil.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		return false, err
+	}
+
+	var payload lockPayload
+	if err := json.Unmarshal(body, &payload); err != nil || time.Since(payload.AcquiredAt) < lockStaleAfter {
+		return false, nil
+	}
+
+	if err := c.ObjectDelete(ctx, lockContainer, lockObjectName); err != nil && err != swift.ObjectNotFound {
+		return false, err
+	}
+	return true, nil
+}
+
+// renewLock periodically overwrites the lock object to push back its
+// acquisition timestamp, so that it is never seen as stale while its holder
+// is alive. It stops as soon as stop is closed, and closes stopped once the
+// goroutine has returned.
+func renewLock(ctx context.Context, c *swift.Connection, lockContainer string, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			body, err := json.Marshal(lockPayload{NodeID: nodeID, AcquiredAt: time.Now()})
+			if err != nil {
+				continue
+			}
+			w, err := c.ObjectCreate(ctx, lockContainer, lockObjectName, false, "", "application/json", nil)
+			if err != nil {
+				continue
+			}
+			if _, err := w.Write(body); err == nil {
+				_ = w.Close()
+			} else {
+				_ = w.Close()
+			}
+		}
+	}
+}
+
+func isPreconditionFailed(err error) bool {
+	swiftErr, ok := err.(*swift.Error)
+	return ok && swiftErr.StatusCode == 412
+}
@@ -0,0 +1,123 @@
+package vfsswift
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockSerializesConcurrentAcquires(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	const container = "cozy-io-lock-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	const nbGoroutines = 5
+	var mu sync.Mutex
+	var holders int
+	var maxHolders int
+	var wg sync.WaitGroup
+
+	for i := 0; i < nbGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := Lock(ctx, c, container)
+			require.NoError(t, err)
+
+			mu.Lock()
+			holders++
+			if holders > maxHolders {
+				maxHolders = holders
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			holders--
+			mu.Unlock()
+
+			assert.NoError(t, unlock())
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, 1, maxHolders, "at most one goroutine should hold the lock at a time")
+}
+
+func TestLockWaitsOutLiveHolder(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	const container = "cozy-io-lock-wait-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	unlock1, err := Lock(ctx, c, container)
+	require.NoError(t, err)
+
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(3 * lockPollInterval)
+		assert.NoError(t, unlock1())
+		close(released)
+	}()
+
+	unlock2, err := Lock(ctx, c, container)
+	require.NoError(t, err)
+	select {
+	case <-released:
+	default:
+		t.Fatal("Lock returned before the first holder released it")
+	}
+	assert.NoError(t, unlock2())
+}
+
+func TestLockTimesOutOnLiveHolder(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	const container = "cozy-io-lock-timeout-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	unlock, err := Lock(ctx, c, container)
+	require.NoError(t, err)
+	defer unlock() //nolint:errcheck
+
+	oldTimeout := lockAcquireTimeout
+	lockAcquireTimeout = 2 * lockPollInterval
+	defer func() { lockAcquireTimeout = oldTimeout }()
+
+	_, err = Lock(ctx, c, container)
+	assert.ErrorIs(t, err, ErrLocked)
+}
+
+func TestLockBreaksStaleLock(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	const container = "cozy-io-lock-stale-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	lockContainer := container + lockContainerSuffix
+	require.NoError(t, c.ContainerCreate(ctx, lockContainer, nil))
+
+	stalePayload := lockPayload{NodeID: "ghost", AcquiredAt: time.Now().Add(-2 * lockStaleAfter)}
+	body, err := json.Marshal(stalePayload)
+	require.NoError(t, err)
+	require.NoError(t, c.ObjectPutBytes(ctx, lockContainer, lockObjectName, body, "application/json"))
+
+	unlock, err := Lock(ctx, c, container)
+	require.NoError(t, err)
+	assert.NoError(t, unlock())
+}
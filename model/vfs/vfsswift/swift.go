@@ -3,6 +3,8 @@ package vfsswift
 import (
 	"context"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cozy/cozy-stack/pkg/utils"
@@ -11,19 +13,56 @@ import (
 )
 
 // maxNbFilesToDelete is the maximal number of files that we will try to delete
-// in a single call to swift.
-const maxNbFilesToDelete = 8000
+// in a single call to swift. It is a var, not a const, so that tests can
+// shrink it to exercise the multi-batch path without creating huge fixtures.
+var maxNbFilesToDelete = 8000
 
 // maxSimultaneousCalls is the maximal number of simultaneous calls to Swift to
 // delete files in the same container.
-const maxSimultaneousCalls = 8
+var maxSimultaneousCalls = 8
+
+// archiveObjectSeparator separates the original container name from the
+// object name in the keys used inside ArchiveContainer, e.g.
+// "cozy-io-cozy-xxx/some/file" for an object named "some/file" that used to
+// live in the "cozy-io-cozy-xxx" container.
+const archiveObjectSeparator = "/"
+
+// archiveOriginHeader stores the name of the container an archived object
+// was moved from, as object metadata. RestoreFromArchive does not read it:
+// it finds archived objects by the container name prefix of their keys.
+const archiveOriginHeader = "X-Object-Meta-Archive-Origin"
+
+// archiveOriginRemoveHeader is the companion "remove metadata" header Swift
+// expects on a COPY to actually drop archiveOriginHeader, rather than merely
+// setting it to an empty value.
+const archiveOriginRemoveHeader = "X-Remove-Object-Meta-Archive-Origin"
 
 var errFailFast = errors.New("fail fast")
 
+// ArchiveContainer is the name of the container in which objects are moved
+// when a container is deleted, instead of being deleted right away. When
+// empty (the default), DeleteContainer falls back to its previous
+// destructive behavior.
+var ArchiveContainer string
+
+// ExpireAfter is the TTL given to objects moved to ArchiveContainer, via
+// Swift's X-Delete-After header. Swift will take care of purging them once
+// this delay has passed.
+var ExpireAfter = 30 * 24 * time.Hour
+
 // DeleteContainer removes all the files inside the given container, and then
-// deletes it.
+// deletes it. If ArchiveContainer is configured, the objects are first moved
+// to that container (with an expiration date) instead of being deleted
+// outright, which gives a recoverable safety net for instance deletion. See
+// RestoreFromArchive to undo this.
 func DeleteContainer(ctx context.Context, c *swift.Connection, container string) error {
-	_, _, err := c.Container(ctx, container)
+	unlock, err := Lock(ctx, c, container)
+	if err != nil {
+		return err
+	}
+	defer unlock() //nolint:errcheck
+
+	_, _, err = c.Container(ctx, container)
 	if err == swift.ContainerNotFound {
 		return nil
 	}
@@ -35,7 +74,11 @@ func DeleteContainer(ctx context.Context, c *swift.Connection, container string)
 		return err
 	}
 	if len(objectNames) > 0 {
-		if err = deleteContainerFiles(ctx, c, container, objectNames); err != nil {
+		if ArchiveContainer != "" {
+			if err = MoveContainerToArchive(ctx, c, container, objectNames); err != nil {
+				return err
+			}
+		} else if err = deleteContainerFiles(ctx, c, container, objectNames); err != nil {
 			return err
 		}
 	}
@@ -56,6 +99,17 @@ func DeleteContainer(ctx context.Context, c *swift.Connection, container string)
 }
 
 func deleteContainerFiles(ctx context.Context, c *swift.Connection, container string, objectNames []string) error {
+	return batchInParallel(objectNames, func(batch []string) error {
+		_, err := c.BulkDelete(ctx, container, batch)
+		return err
+	})
+}
+
+// batchInParallel splits objectNames into batches of at most
+// maxNbFilesToDelete elements, and runs fn on each batch, with at most
+// maxSimultaneousCalls batches in flight at the same time. It returns a
+// multierror aggregating the errors returned by fn, if any.
+func batchInParallel(objectNames []string, fn func(batch []string) error) error {
 	nb := 1 + (len(objectNames)-1)/maxNbFilesToDelete
 	ch := make(chan error)
 
@@ -72,11 +126,10 @@ func deleteContainerFiles(ctx context.Context, c *swift.Connection, container st
 		if end > len(objectNames) {
 			end = len(objectNames)
 		}
-		objectToDelete := objectNames[begin:end]
+		batch := objectNames[begin:end]
 		go func() {
 			k := <-tokens
-			_, err := c.BulkDelete(ctx, container, objectToDelete)
-			ch <- err
+			ch <- fn(batch)
 			tokens <- k
 		}()
 	}
@@ -93,3 +146,83 @@ func deleteContainerFiles(ctx context.Context, c *swift.Connection, container st
 	}
 	return errm
 }
+
+// MoveContainerToArchive copies the objects named by objectNames from
+// container into ArchiveContainer (creating it if necessary), tagging each
+// copy with an X-Delete-After header so that Swift purges it automatically
+// after ExpireAfter, and with archiveOriginHeader recording the container it
+// came from. Once every object has been
+// copied, it removes them from the live container.
+func MoveContainerToArchive(ctx context.Context, c *swift.Connection, container string, objectNames []string) error {
+	if ArchiveContainer == "" {
+		return errors.New("vfsswift: ArchiveContainer is not configured")
+	}
+	if err := c.ContainerCreate(ctx, ArchiveContainer, nil); err != nil {
+		return err
+	}
+
+	h := swift.Headers{
+		"X-Delete-After":    strconv.FormatInt(int64(ExpireAfter/time.Second), 10),
+		archiveOriginHeader: container,
+	}
+	if err := batchInParallel(objectNames, func(batch []string) error {
+		var errm error
+		for _, name := range batch {
+			archiveName := container + archiveObjectSeparator + name
+			if _, err := c.ObjectCopy(ctx, container, name, ArchiveContainer, archiveName, h); err != nil {
+				errm = multierror.Append(errm, err)
+			}
+		}
+		return errm
+	}); err != nil {
+		return err
+	}
+
+	return deleteContainerFiles(ctx, c, container, objectNames)
+}
+
+// RestoreFromArchive walks ArchiveContainer and re-uploads to container
+// every object that was archived from it by MoveContainerToArchive,
+// removing it from the archive afterwards. It is the counterpart of
+// MoveContainerToArchive, and is meant to be used as a recovery tool when an
+// instance deletion needs to be undone before ExpireAfter has elapsed.
+func RestoreFromArchive(ctx context.Context, c *swift.Connection, container string) error {
+	if ArchiveContainer == "" {
+		return errors.New("vfsswift: ArchiveContainer is not configured")
+	}
+	prefix := container + archiveObjectSeparator
+	archivedNames, err := c.ObjectNamesAll(ctx, ArchiveContainer, &swift.ObjectsOpts{
+		Prefix: prefix,
+	})
+	if err != nil {
+		return err
+	}
+	if len(archivedNames) == 0 {
+		return nil
+	}
+
+	if err := c.ContainerCreate(ctx, container, nil); err != nil {
+		return err
+	}
+
+	if err := batchInParallel(archivedNames, func(batch []string) error {
+		var errm error
+		for _, archiveName := range batch {
+			name := strings.TrimPrefix(archiveName, prefix)
+			// Clear the expiration and origin metadata on the restored
+			// object: it is live again, not an archive entry.
+			h := swift.Headers{
+				"X-Remove-Delete-After":   "true",
+				archiveOriginRemoveHeader: "true",
+			}
+			if _, err := c.ObjectCopy(ctx, ArchiveContainer, archiveName, container, name, h); err != nil {
+				errm = multierror.Append(errm, err)
+			}
+		}
+		return errm
+	}); err != nil {
+		return err
+	}
+
+	return deleteContainerFiles(ctx, c, ArchiveContainer, archivedNames)
+}
@@ -0,0 +1,179 @@
+package vfsswift
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ncw/swift/v2"
+	"github.com/ncw/swift/v2/swifttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConnection(t *testing.T) (*swift.Connection, func()) {
+	t.Helper()
+	srv, err := swifttest.NewSwiftServer("localhost")
+	require.NoError(t, err)
+
+	c := &swift.Connection{
+		UserName: "swifttest",
+		ApiKey:   "swifttest",
+		AuthUrl:  srv.AuthURL,
+	}
+	require.NoError(t, c.Authenticate(context.Background()))
+
+	return c, srv.Close
+}
+
+func TestDeleteContainerArchivesObjects(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	oldArchive, oldExpire := ArchiveContainer, ExpireAfter
+	ArchiveContainer = "archive-test"
+	ExpireAfter = time.Hour
+	defer func() { ArchiveContainer, ExpireAfter = oldArchive, oldExpire }()
+
+	const container = "cozy-io-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	const nbObjects = 42
+	for i := 0; i < nbObjects; i++ {
+		name := fmt.Sprintf("file-%d", i)
+		require.NoError(t, c.ObjectPutString(ctx, container, name, "hello", ""))
+	}
+
+	require.NoError(t, DeleteContainer(ctx, c, container))
+
+	_, _, err := c.Container(ctx, container)
+	assert.Equal(t, swift.ContainerNotFound, err)
+
+	archived, err := c.ObjectNamesAll(ctx, ArchiveContainer, &swift.ObjectsOpts{
+		Prefix: container + archiveObjectSeparator,
+	})
+	require.NoError(t, err)
+	assert.Len(t, archived, nbObjects)
+}
+
+func TestDeleteContainerWithoutArchive(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	oldArchive := ArchiveContainer
+	ArchiveContainer = ""
+	defer func() { ArchiveContainer = oldArchive }()
+
+	const container = "cozy-io-test-noarchive"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+	require.NoError(t, c.ObjectPutString(ctx, container, "file-0", "hello", ""))
+
+	require.NoError(t, DeleteContainer(ctx, c, container))
+
+	_, _, err := c.Container(ctx, container)
+	assert.Equal(t, swift.ContainerNotFound, err)
+}
+
+func TestDeleteContainerMultipleBatches(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	oldMaxNb, oldMaxCalls := maxNbFilesToDelete, maxSimultaneousCalls
+	maxNbFilesToDelete = 3
+	maxSimultaneousCalls = 2
+	defer func() { maxNbFilesToDelete, maxSimultaneousCalls = oldMaxNb, oldMaxCalls }()
+
+	const container = "cozy-io-multibatch-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	// With maxNbFilesToDelete shrunk to 3, this spans several batches run
+	// through the maxSimultaneousCalls-sized worker pool, exercising
+	// batchInParallel's multi-batch/parallel path.
+	const nbObjects = 25
+	for i := 0; i < nbObjects; i++ {
+		name := fmt.Sprintf("file-%d", i)
+		require.NoError(t, c.ObjectPutString(ctx, container, name, "hello", ""))
+	}
+
+	require.NoError(t, DeleteContainer(ctx, c, container))
+
+	_, _, err := c.Container(ctx, container)
+	assert.Equal(t, swift.ContainerNotFound, err)
+}
+
+func TestMoveContainerToArchiveMultipleBatches(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	oldArchive, oldExpire := ArchiveContainer, ExpireAfter
+	oldMaxNb, oldMaxCalls := maxNbFilesToDelete, maxSimultaneousCalls
+	ArchiveContainer = "archive-multibatch-test"
+	ExpireAfter = time.Hour
+	maxNbFilesToDelete = 3
+	maxSimultaneousCalls = 2
+	defer func() {
+		ArchiveContainer, ExpireAfter = oldArchive, oldExpire
+		maxNbFilesToDelete, maxSimultaneousCalls = oldMaxNb, oldMaxCalls
+	}()
+
+	const container = "cozy-io-archive-multibatch-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	const nbObjects = 31
+	for i := 0; i < nbObjects; i++ {
+		name := fmt.Sprintf("file-%d", i)
+		require.NoError(t, c.ObjectPutString(ctx, container, name, "hello", ""))
+	}
+
+	require.NoError(t, DeleteContainer(ctx, c, container))
+
+	archived, err := c.ObjectNamesAll(ctx, ArchiveContainer, &swift.ObjectsOpts{
+		Prefix: container + archiveObjectSeparator,
+	})
+	require.NoError(t, err)
+	assert.Len(t, archived, nbObjects)
+}
+
+func TestRestoreFromArchive(t *testing.T) {
+	ctx := context.Background()
+	c, closeSrv := newTestConnection(t)
+	defer closeSrv()
+
+	oldArchive, oldExpire := ArchiveContainer, ExpireAfter
+	ArchiveContainer = "archive-restore-test"
+	ExpireAfter = time.Hour
+	defer func() { ArchiveContainer, ExpireAfter = oldArchive, oldExpire }()
+
+	const container = "cozy-io-restore-test"
+	require.NoError(t, c.ContainerCreate(ctx, container, nil))
+
+	const nbObjects = 256
+	for i := 0; i < nbObjects; i++ {
+		name := fmt.Sprintf("dir/file-%d", i)
+		require.NoError(t, c.ObjectPutString(ctx, container, name, "hello", ""))
+	}
+
+	require.NoError(t, DeleteContainer(ctx, c, container))
+
+	require.NoError(t, RestoreFromArchive(ctx, c, container))
+
+	names, err := c.ObjectNamesAll(ctx, container, nil)
+	require.NoError(t, err)
+	assert.Len(t, names, nbObjects)
+
+	archived, err := c.ObjectNamesAll(ctx, ArchiveContainer, &swift.ObjectsOpts{
+		Prefix: container + archiveObjectSeparator,
+	})
+	require.NoError(t, err)
+	assert.Len(t, archived, 0)
+
+	_, h, err := c.Object(ctx, container, "dir/file-0")
+	require.NoError(t, err)
+	_, hasOriginHeader := h[archiveOriginHeader]
+	assert.False(t, hasOriginHeader, "restored object should not keep the archive origin metadata")
+}
@@ -3,7 +3,6 @@ package logger
 import (
 	"context"
 	"io/ioutil"
-	"strings"
 	"sync"
 	"time"
 
@@ -12,12 +11,6 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	debugRedisAddChannel = "add:log-debug"
-	debugRedisRmvChannel = "rmv:log-debug"
-	debugRedisPrefix     = "debug:"
-)
-
 var opts Options
 var loggers = make(map[string]domainEntry)
 var loggersMu sync.RWMutex
@@ -27,11 +20,18 @@ type Options struct {
 	Syslog bool
 	Level  string
 	Redis  redis.UniversalClient
+
+	// RingBufferSize is the number of log entries kept per debugged domain,
+	// used to serve TailDomain. SubscribeDomain streams only receive entries
+	// logged after they subscribe. It defaults to defaultRingBufferSize when
+	// left at zero.
+	RingBufferSize int
 }
 
 type domainEntry struct {
 	log       *logrus.Logger
 	expiredAt *time.Time
+	buf       *ringBuffer
 }
 
 func (entry *domainEntry) Expired() bool {
@@ -65,8 +65,13 @@ func Init(opt Options) error {
 	}
 	if cli := opt.Redis; cli != nil {
 		ctx := context.Background()
-		go subscribeLoggersDebug(ctx, cli)
-		go loadDebug(ctx, cli)
+		if err := ensureDebugConsumerGroup(ctx, cli); err != nil {
+			return err
+		}
+		// The replayed domains are built from opts (ring buffer size, syslog).
+		opts = opt
+		replayDebugState(ctx, cli)
+		go consumeDebugStream(ctx, cli)
 	}
 	opts = opt
 	return nil
@@ -90,7 +95,7 @@ func Clone(in *logrus.Logger) *logrus.Logger {
 func AddDebugDomain(domain string, ttl time.Duration) error {
 	if cli := opts.Redis; cli != nil {
 		ctx := context.Background()
-		return publishDebug(ctx, cli, debugRedisAddChannel, domain, ttl)
+		return publishDebugEvent(ctx, cli, debugOpAdd, domain, ttl)
 	}
 	addDebugDomain(domain, ttl)
 	return nil
@@ -100,7 +105,7 @@ func AddDebugDomain(domain string, ttl time.Duration) error {
 func RemoveDebugDomain(domain string) error {
 	if cli := opts.Redis; cli != nil {
 		ctx := context.Background()
-		return publishDebug(ctx, cli, debugRedisRmvChannel, domain, 0)
+		return publishDebugEvent(ctx, cli, debugOpRemove, domain, 0)
 	}
 	removeDebugDomain(domain)
 	return nil
@@ -141,61 +146,20 @@ func addDebugDomain(domain string, ttl time.Duration) {
 			logger.Out = ioutil.Discard
 		}
 	}
+	buf := newRingBuffer(opts.RingBufferSize)
+	logger.Hooks.Add(&ringBufferHook{buf: buf})
 	expiredAt := time.Now().Add(ttl)
-	loggers[domain] = domainEntry{logger, &expiredAt}
+	loggers[domain] = domainEntry{logger, &expiredAt, buf}
 }
 
 func removeDebugDomain(domain string) {
 	loggersMu.Lock()
-	defer loggersMu.Unlock()
+	entry, ok := loggers[domain]
 	delete(loggers, domain)
-}
-
-func subscribeLoggersDebug(ctx context.Context, cli redis.UniversalClient) {
-	sub := cli.Subscribe(ctx, debugRedisAddChannel, debugRedisRmvChannel)
-	for msg := range sub.Channel() {
-		parts := strings.Split(msg.Payload, "/")
-		domain := parts[0]
-		switch msg.Channel {
-		case debugRedisAddChannel:
-			var ttl time.Duration
-			if len(parts) >= 2 {
-				ttl, _ = time.ParseDuration(parts[1])
-			}
-			addDebugDomain(domain, ttl)
-		case debugRedisRmvChannel:
-			removeDebugDomain(domain)
-		}
-	}
-}
-
-func loadDebug(ctx context.Context, cli redis.UniversalClient) {
-	keys, err := cli.Keys(ctx, debugRedisPrefix+"*").Result()
-	if err != nil {
-		return
-	}
-	for _, key := range keys {
-		ttl, err := cli.TTL(ctx, key).Result()
-		if err != nil {
-			continue
-		}
-		domain := strings.TrimPrefix(key, debugRedisPrefix)
-		addDebugDomain(domain, ttl)
-	}
-}
-
-func publishDebug(ctx context.Context, cli redis.UniversalClient, channel, domain string, ttl time.Duration) error {
-	err := cli.Publish(ctx, channel, domain+"/"+ttl.String()).Err()
-	if err != nil {
-		return err
-	}
-	key := debugRedisPrefix + domain
-	if channel == debugRedisAddChannel {
-		err = cli.Set(ctx, key, 0, ttl).Err()
-	} else {
-		err = cli.Del(ctx, key).Err()
+	loggersMu.Unlock()
+	if ok {
+		entry.buf.closeAll()
 	}
-	return err
 }
 
 // DebugExpiration returns the expiration date for the debug mode for the
@@ -215,3 +179,49 @@ func DebugExpiration(domain string) *time.Time {
 func IsDebug(logger *logrus.Entry) bool {
 	return logger.Logger.Level == logrus.DebugLevel
 }
+
+// TailDomain returns up to the n most recent log entries captured for the
+// given domain since its debug mode was activated. It returns nil if the
+// domain is not currently being debugged. A n <= 0 returns every entry
+// still held in the buffer.
+func TailDomain(domain string, n int) []Entry {
+	loggersMu.RLock()
+	entry, ok := loggers[domain]
+	loggersMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if entry.Expired() {
+		removeDebugDomain(domain)
+		return nil
+	}
+	return entry.buf.tail(n)
+}
+
+// SubscribeDomain returns a channel on which every new log entry for the
+// given domain is sent as it is produced, so that an operator can follow a
+// debug session live (see the SSE handler in web/admin) without shell
+// access to the server. The channel is closed when
+// ctx is done, or when the domain's debug mode is turned off. It returns
+// an already-closed channel if the domain is not currently being debugged.
+func SubscribeDomain(ctx context.Context, domain string) <-chan Entry {
+	loggersMu.RLock()
+	entry, ok := loggers[domain]
+	loggersMu.RUnlock()
+	if ok && entry.Expired() {
+		removeDebugDomain(domain)
+		ok = false
+	}
+	if !ok {
+		ch := make(chan Entry)
+		close(ch)
+		return ch
+	}
+
+	ch, cancel := entry.buf.subscribe()
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return ch
+}
@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultRingBufferSize is the number of log entries kept per domain when no
+// explicit size is configured via Options.RingBufferSize.
+const defaultRingBufferSize = 1000
+
+// Entry is a single log line captured for a debugged domain, in a form
+// suitable for JSON serialization (e.g. to stream back to an operator).
+// TailDomain and SubscribeDomain (in logger.go) are what the admin debug-logs SSE
+// handler (web/admin) calls to serve recent + live entries for a domain.
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ringBuffer is a bounded, thread-safe buffer of the most recent log
+// Entry values for a domain, that can also fan-out newly pushed entries to
+// live subscribers.
+type ringBuffer struct {
+	mu          sync.Mutex
+	entries     []Entry
+	start       int
+	count       int
+	subscribers map[chan Entry]struct{}
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	if size <= 0 {
+		size = defaultRingBufferSize
+	}
+	return &ringBuffer{
+		entries:     make([]Entry, size),
+		subscribers: make(map[chan Entry]struct{}),
+	}
+}
+
+func (b *ringBuffer) push(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	size := len(b.entries)
+	if b.count < size {
+		b.entries[(b.start+b.count)%size] = e
+		b.count++
+	} else {
+		b.entries[b.start] = e
+		b.start = (b.start + 1) % size
+	}
+	// Fan out to subscribers while still holding b.mu, so that cancel/closeAll
+	// cannot close a channel concurrently with a send on it.
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// The subscriber is too slow to keep up: drop the entry rather
+			// than blocking the logger.
+		}
+	}
+}
+
+func (b *ringBuffer) tail(n int) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n <= 0 || n > b.count {
+		n = b.count
+	}
+	size := len(b.entries)
+	out := make([]Entry, n)
+	for i := 0; i < n; i++ {
+		out[i] = b.entries[(b.start+b.count-n+i)%size]
+	}
+	return out
+}
+
+// subscribe registers a new live subscriber and returns the channel on
+// which new entries will be sent, along with a function to unregister it.
+// The caller must keep draining the channel (or call the returned cancel
+// func) to avoid entries being dropped for this subscriber.
+func (b *ringBuffer) subscribe() (chan Entry, func()) {
+	ch := make(chan Entry, 16)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	cancel := func() {
+		b.mu.Lock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+		b.mu.Unlock()
+	}
+	return ch, cancel
+}
+
+// closeAll unregisters and closes every live subscriber, e.g. when the
+// domain it belongs to is no longer being debugged.
+func (b *ringBuffer) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// ringBufferHook is a logrus.Hook that mirrors every log entry into a
+// domain's ringBuffer.
+type ringBufferHook struct {
+	buf *ringBuffer
+}
+
+func (h *ringBufferHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *ringBufferHook) Fire(e *logrus.Entry) error {
+	fields := make(map[string]interface{}, len(e.Data))
+	for k, v := range e.Data {
+		if k == "domain" {
+			continue
+		}
+		fields[k] = v
+	}
+	h.buf.push(Entry{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+		Fields:  fields,
+	})
+	return nil
+}
@@ -0,0 +1,109 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingBufferTail(t *testing.T) {
+	buf := newRingBuffer(3)
+	for i := 0; i < 5; i++ {
+		buf.push(Entry{Message: string(rune('a' + i))})
+	}
+
+	tail := buf.tail(10)
+	require.Len(t, tail, 3)
+	assert.Equal(t, "c", tail[0].Message)
+	assert.Equal(t, "d", tail[1].Message)
+	assert.Equal(t, "e", tail[2].Message)
+
+	last2 := buf.tail(2)
+	require.Len(t, last2, 2)
+	assert.Equal(t, "d", last2[0].Message)
+	assert.Equal(t, "e", last2[1].Message)
+}
+
+func TestRingBufferSubscribe(t *testing.T) {
+	buf := newRingBuffer(10)
+	ch, cancel := buf.subscribe()
+	defer cancel()
+
+	buf.push(Entry{Message: "hello"})
+
+	select {
+	case e := <-ch:
+		assert.Equal(t, "hello", e.Message)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the subscribed entry")
+	}
+}
+
+func TestRingBufferPushDuringCancelDoesNotPanic(t *testing.T) {
+	buf := newRingBuffer(10)
+
+	const nbRounds = 200
+	for i := 0; i < nbRounds; i++ {
+		_, cancel := buf.subscribe()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			buf.push(Entry{Message: "race"})
+		}()
+		go func() {
+			defer wg.Done()
+			cancel()
+		}()
+		wg.Wait()
+	}
+}
+
+func TestRingBufferPushDuringCloseAllDoesNotPanic(t *testing.T) {
+	buf := newRingBuffer(10)
+
+	const nbRounds = 200
+	for i := 0; i < nbRounds; i++ {
+		buf.subscribe()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			buf.push(Entry{Message: "race"})
+		}()
+		go func() {
+			defer wg.Done()
+			buf.closeAll()
+		}()
+		wg.Wait()
+	}
+}
+
+func TestTailAndSubscribeDomain(t *testing.T) {
+	defer RemoveDebugDomain("example.com") //nolint:errcheck
+
+	require.NoError(t, AddDebugDomain("example.com", time.Minute))
+	WithDomain("example.com").Debug("hi")
+
+	tail := TailDomain("example.com", 0)
+	require.Len(t, tail, 1)
+	assert.Equal(t, "hi", tail[0].Message)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := SubscribeDomain(ctx, "example.com")
+	WithDomain("example.com").Debug("live")
+	select {
+	case e := <-ch:
+		assert.Equal(t, "live", e.Message)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the live entry")
+	}
+
+	require.NoError(t, RemoveDebugDomain("example.com"))
+	assert.Nil(t, TailDomain("example.com", 0))
+}
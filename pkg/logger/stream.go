@@ -0,0 +1,151 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// debugStreamKey is the Redis Stream on which AddDebugDomain/RemoveDebugDomain
+// events are published, so that every cozy-stack node can apply them to its
+// own in-memory debug list.
+const debugStreamKey = "log-debug-events"
+
+// debugStreamMaxLen is the approximate length at which the stream is
+// trimmed (XADD ... MAXLEN ~ N), keeping enough history to replay the
+// longest possible debug TTL without letting the stream grow unbounded.
+const debugStreamMaxLen = 10000
+
+// debugReplayWindow bounds how far back a node reads the stream on start to
+// reconstruct the current debug list, i.e. the longest TTL AddDebugDomain
+// is expected to be called with.
+const debugReplayWindow = 7 * 24 * time.Hour
+
+// debugOp identifies the kind of event carried by a stream entry.
+type debugOp string
+
+const (
+	debugOpAdd    debugOp = "add"
+	debugOpRemove debugOp = "rmv"
+)
+
+// debugConsumer is this node's identity within its own consumer group: the
+// group is named after the hostname (so that every node gets its own copy
+// of every event, mimicking the fan-out of the former pub/sub channels),
+// and a single, stable consumer name lets the node reclaim its pending
+// entries across restarts.
+var debugConsumer = "node"
+
+func debugConsumerGroup() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host
+}
+
+// publishDebugEvent appends an add/remove event to the debug stream. It
+// replaces the former Publish+Set pair: the stream entry is both the
+// notification and the durable record other nodes replay on start.
+func publishDebugEvent(ctx context.Context, cli redis.UniversalClient, op debugOp, domain string, ttl time.Duration) error {
+	return cli.XAdd(ctx, &redis.XAddArgs{
+		Stream: debugStreamKey,
+		MaxLen: debugStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"op":        string(op),
+			"domain":    domain,
+			"ttl":       ttl.String(),
+			"issued_at": time.Now().Format(time.RFC3339Nano),
+		},
+	}).Err()
+}
+
+// ensureDebugConsumerGroup creates this node's consumer group on the debug
+// stream if it does not already exist, positioned at "$" (only future
+// entries): the entries needed to reconstruct the current state are read
+// separately by replayDebugState, which does not depend on the group.
+func ensureDebugConsumerGroup(ctx context.Context, cli redis.UniversalClient) error {
+	err := cli.XGroupCreateMkStream(ctx, debugStreamKey, debugConsumerGroup(), "$").Err()
+	if err != nil && !isBusyGroup(err) {
+		return err
+	}
+	return nil
+}
+
+func isBusyGroup(err error) bool {
+	return err != nil && err.Error() == "BUSYGROUP Consumer Group name already exists"
+}
+
+// replayDebugState reads every event emitted in the last debugReplayWindow
+// and applies it in order, so that a node starting up (or restarting mid
+// burst of toggles) ends up with the same debug list as every other node,
+// instead of relying on a Keys(debug:*) scan.
+func replayDebugState(ctx context.Context, cli redis.UniversalClient) {
+	start := fmt.Sprintf("%d", time.Now().Add(-debugReplayWindow).UnixMilli())
+	msgs, err := cli.XRange(ctx, debugStreamKey, start, "+").Result()
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		applyDebugEvent(msg.Values)
+	}
+}
+
+// consumeDebugStream first re-applies any entries that were delivered to
+// this node's consumer but never acknowledged (e.g. because it crashed
+// mid-processing), then blocks reading new entries forever, applying and
+// acknowledging each one as it is received.
+func consumeDebugStream(ctx context.Context, cli redis.UniversalClient) {
+	readDebugStream(ctx, cli, "0")
+	for {
+		readDebugStream(ctx, cli, ">")
+	}
+}
+
+func readDebugStream(ctx context.Context, cli redis.UniversalClient, start string) {
+	res, err := cli.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    debugConsumerGroup(),
+		Consumer: debugConsumer,
+		Streams:  []string{debugStreamKey, start},
+		Count:    100,
+		Block:    5 * time.Second,
+	}).Result()
+	if err != nil {
+		return
+	}
+	for _, stream := range res {
+		for _, msg := range stream.Messages {
+			applyDebugEvent(msg.Values)
+			cli.XAck(ctx, debugStreamKey, debugConsumerGroup(), msg.ID)
+		}
+	}
+}
+
+func applyDebugEvent(values map[string]interface{}) {
+	domain, _ := values["domain"].(string)
+	if domain == "" {
+		return
+	}
+	switch debugOp(fmt.Sprint(values["op"])) {
+	case debugOpAdd:
+		ttl, _ := time.ParseDuration(fmt.Sprint(values["ttl"]))
+		issuedAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(values["issued_at"]))
+		if err != nil {
+			issuedAt = time.Now()
+		}
+		// Replaying an old event must not re-arm the domain with a full,
+		// fresh TTL: only what was left of it when the event was issued
+		// still applies.
+		remaining := time.Until(issuedAt.Add(ttl))
+		if remaining <= 0 {
+			return
+		}
+		addDebugDomain(domain, remaining)
+	case debugOpRemove:
+		removeDebugDomain(domain)
+	}
+}
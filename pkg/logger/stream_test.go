@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyDebugEvent(t *testing.T) {
+	defer RemoveDebugDomain("stream-test.example.com") //nolint:errcheck
+
+	applyDebugEvent(map[string]interface{}{
+		"op":     string(debugOpAdd),
+		"domain": "stream-test.example.com",
+		"ttl":    time.Minute.String(),
+	})
+	assert.NotNil(t, DebugExpiration("stream-test.example.com"))
+
+	applyDebugEvent(map[string]interface{}{
+		"op":     string(debugOpRemove),
+		"domain": "stream-test.example.com",
+	})
+	assert.Nil(t, DebugExpiration("stream-test.example.com"))
+}
+
+func TestApplyDebugEventIgnoresMissingDomain(t *testing.T) {
+	require.NotPanics(t, func() {
+		applyDebugEvent(map[string]interface{}{"op": string(debugOpAdd)})
+	})
+}
+
+func TestApplyDebugEventDoesNotReArmAlreadyExpiredReplay(t *testing.T) {
+	const domain = "replay-expired.example.com"
+	defer RemoveDebugDomain(domain) //nolint:errcheck
+
+	applyDebugEvent(map[string]interface{}{
+		"op":        string(debugOpAdd),
+		"domain":    domain,
+		"ttl":       time.Minute.String(),
+		"issued_at": time.Now().Add(-time.Hour).Format(time.RFC3339Nano),
+	})
+	assert.Nil(t, DebugExpiration(domain), "an event whose TTL already elapsed must not re-arm the domain")
+}
+
+func TestApplyDebugEventUsesTTLRemainingSinceIssuedAt(t *testing.T) {
+	const domain = "replay-remaining.example.com"
+	defer RemoveDebugDomain(domain) //nolint:errcheck
+
+	issuedAt := time.Now().Add(-5 * time.Minute)
+	applyDebugEvent(map[string]interface{}{
+		"op":        string(debugOpAdd),
+		"domain":    domain,
+		"ttl":       (10 * time.Minute).String(),
+		"issued_at": issuedAt.Format(time.RFC3339Nano),
+	})
+	expiresAt := DebugExpiration(domain)
+	require.NotNil(t, expiresAt)
+	// A fresh 10-minute TTL from now would expire around issuedAt+15m; only
+	// about 5 minutes should remain since issuedAt.
+	assert.WithinDuration(t, issuedAt.Add(10*time.Minute), *expiresAt, time.Second)
+}
+
+func newMiniredisClient(t *testing.T) redis.UniversalClient {
+	t.Helper()
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func TestPublishAndReplayDebugState(t *testing.T) {
+	ctx := context.Background()
+	cli := newMiniredisClient(t)
+
+	const domain = "redis-replay.example.com"
+	defer RemoveDebugDomain(domain) //nolint:errcheck
+
+	require.NoError(t, publishDebugEvent(ctx, cli, debugOpAdd, domain, time.Minute))
+
+	replayDebugState(ctx, cli)
+	assert.NotNil(t, DebugExpiration(domain))
+}
+
+func TestConsumeDebugStreamAppliesNewEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli := newMiniredisClient(t)
+
+	const domain = "redis-consume.example.com"
+	defer RemoveDebugDomain(domain) //nolint:errcheck
+
+	require.NoError(t, ensureDebugConsumerGroup(ctx, cli))
+	go consumeDebugStream(ctx, cli)
+
+	require.NoError(t, publishDebugEvent(ctx, cli, debugOpAdd, domain, time.Minute))
+
+	require.Eventually(t, func() bool {
+		return DebugExpiration(domain) != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, publishDebugEvent(ctx, cli, debugOpRemove, domain, 0))
+	require.Eventually(t, func() bool {
+		return DebugExpiration(domain) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
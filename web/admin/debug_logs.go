@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/labstack/echo/v4"
+)
+
+// debugLogsSSE streams the recent and live debug log entries for a domain
+// back to an operator over Server-Sent Events, so that a debug session can
+// be followed without shell access to the server. It requires the domain to
+// currently be in debug mode (see AddDebugDomain).
+//
+// The "tail" query parameter controls how many past entries are replayed
+// before switching to live streaming (defaults to 100).
+func debugLogsSSE(c echo.Context) error {
+	domain := c.Param("domain")
+
+	n, _ := strconv.Atoi(c.QueryParam("tail"))
+	if n <= 0 {
+		n = 100
+	}
+
+	past := logger.TailDomain(domain, n)
+	if past == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "domain is not in debug mode")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	for _, e := range past {
+		if err := writeLogEntry(res, e); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	ch := logger.SubscribeDomain(c.Request().Context(), domain)
+	for e := range ch {
+		if err := writeLogEntry(res, e); err != nil {
+			return nil
+		}
+		res.Flush()
+	}
+	return nil
+}
+
+func writeLogEntry(res *echo.Response, e logger.Entry) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "data: %s\n\n", body)
+	return err
+}
+
+// Routes sets the routing for the admin debug-log streaming endpoints.
+func Routes(router *echo.Group) {
+	router.GET("/instances/:domain/debug/logs", debugLogsSSE)
+}
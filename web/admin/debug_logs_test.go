@@ -0,0 +1,106 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncResponseWriter is a minimal http.ResponseWriter backed by a
+// mutex-guarded buffer, so that tests can safely read its body from a
+// goroutine other than the one writing to it (unlike
+// httptest.ResponseRecorder, whose Body is a plain, unsynchronized
+// *bytes.Buffer).
+type syncResponseWriter struct {
+	mu     sync.Mutex
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newSyncResponseWriter() *syncResponseWriter {
+	return &syncResponseWriter{header: make(http.Header)}
+}
+
+func (w *syncResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *syncResponseWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.Write(p)
+}
+
+func (w *syncResponseWriter) WriteHeader(int) {}
+
+// Flush satisfies http.Flusher, which echo.Response.Flush expects.
+func (w *syncResponseWriter) Flush() {}
+
+func (w *syncResponseWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.String()
+}
+
+func TestDebugLogsSSEUnknownDomain(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/instances/unknown.example.com/debug/logs", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("domain")
+	c.SetParamValues("unknown.example.com")
+
+	err := debugLogsSSE(c)
+	require.Error(t, err)
+	httpErr, ok := err.(*echo.HTTPError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, httpErr.Code)
+}
+
+func TestDebugLogsSSEStreamsTailAndLiveEntries(t *testing.T) {
+	const domain = "debug-sse-test.example.com"
+	defer logger.RemoveDebugDomain(domain) //nolint:errcheck
+
+	require.NoError(t, logger.AddDebugDomain(domain, time.Minute))
+	logger.WithDomain(domain).Debug("past entry")
+
+	e := echo.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/instances/"+domain+"/debug/logs", nil).WithContext(ctx)
+	rec := newSyncResponseWriter()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("domain")
+	c.SetParamValues(domain)
+
+	done := make(chan struct{})
+	go func() {
+		_ = debugLogsSSE(c)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(rec.String(), "past entry")
+	}, time.Second, 5*time.Millisecond)
+
+	logger.WithDomain(domain).Debug("live entry")
+	require.Eventually(t, func() bool {
+		return strings.Contains(rec.String(), "live entry")
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("debugLogsSSE did not return after its context was canceled")
+	}
+}